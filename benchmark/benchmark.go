@@ -0,0 +1,65 @@
+// Package benchmark runs hey-apm with a fixed, reproducible payload
+// shape against an apm-server. It does not report the resulting
+// throughput anywhere: nothing is written to Elasticsearch and no
+// comparison against previous runs is made.
+package benchmark
+
+import (
+	"context"
+
+	"github.com/elastic/hey-apm/models"
+	"github.com/elastic/hey-apm/worker"
+)
+
+// Run drives a fixed-parameter benchmark and passes the result to
+// report. If input came from a -config file that declares any profiles
+// and no single -profile was selected, Run iterates every profile in
+// turn and runs and reports each one separately.
+func Run(ctx context.Context, input models.Input) error {
+	if input.ConfigPath != "" && input.Profile == "" {
+		profiles, err := models.ListProfiles(input.ConfigPath)
+		if err != nil {
+			return err
+		}
+		if len(profiles) > 0 {
+			for _, profile := range profiles {
+				base := input
+				if input.CLIOverrides != nil {
+					base = input.CLIOverrides.Input
+				}
+				profileInput, err := models.LoadConfig(input.ConfigPath, profile, base)
+				if err != nil {
+					return err
+				}
+				// Reapply the CLI overrides used to build input, so a
+				// flag explicitly passed alongside -config (-apm-url,
+				// -rm, -rd, ...) isn't silently dropped for every
+				// profile but the one parseFlags merged itself.
+				if input.CLIOverrides != nil {
+					profileInput = models.MergeCLIOverFile(input.CLIOverrides.Input, profileInput, input.CLIOverrides.ExplicitlySet)
+				}
+				if err := runOne(ctx, profileInput); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return runOne(ctx, input)
+}
+
+func runOne(ctx context.Context, input models.Input) error {
+	stop := make(chan struct{})
+	var counters worker.Counters
+	if err := worker.Run(ctx, input, "", stop, &counters); err != nil {
+		return err
+	}
+	return report(input, &counters)
+}
+
+func report(input models.Input, stats worker.Stats) error {
+	// report reads neither input nor stats and always returns nil: no
+	// row is indexed into Elasticsearch, and no regression check
+	// against -rm/-rd is made.
+	return nil
+}
@@ -14,6 +14,7 @@ import (
 
 	"go.elastic.co/apm"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 
 	"github.com/elastic/hey-apm/benchmark"
 	"github.com/elastic/hey-apm/models"
@@ -25,6 +26,22 @@ func init() {
 	rand.Seed(1000)
 }
 
+// Benchmark mode trades the tunable -tf-rate/-ef-rate/-t/-e/... flags
+// for a single fixed, reproducible payload shape, so that comparing
+// two apm-server versions compares the same load both times. Like the
+// non-bench defaults, the transaction/error counts are left unbounded
+// and RunTimeout is what ends the run.
+const (
+	benchTransactionRate    = 100
+	benchTransactionBurst   = 10
+	benchErrorRate          = 10
+	benchErrorBurst         = 2
+	benchSpanMaxLimit       = 10
+	benchSpanMinLimit       = 1
+	benchErrorFrameMaxLimit = 10
+	benchErrorFrameMinLimit = 0
+)
+
 func main() {
 	if err := Main(); err != nil {
 		log.Fatal(err)
@@ -64,15 +81,31 @@ func Main() error {
 }
 
 func runWorkers(input models.Input, stop <-chan struct{}) error {
+	counters := make([]*worker.Counters, input.Instances)
+	for i := range counters {
+		counters[i] = &worker.Counters{}
+	}
+
+	if err := startMetricsServer(input.MetricsAddr, counters); err != nil {
+		return err
+	}
+
 	g, ctx := errgroup.WithContext(context.Background())
+
+	if input.InfluxUrl != "" {
+		g.Go(func() error {
+			pushInflux(ctx, input, counters)
+			return nil
+		})
+	}
+
 	for i := 0; i < input.Instances; i++ {
 		idx := i
 		g.Go(func() error {
 			randomDelay := time.Duration(rand.Intn(input.DelayMillis)) * time.Millisecond
 			fmt.Println(fmt.Sprintf("--- Starting instance (%v) in %v milliseconds", idx, randomDelay))
 			time.Sleep(randomDelay)
-			_, err := worker.Run(ctx, input, "", stop)
-			return err
+			return worker.Run(ctx, input, "", stop, counters[idx])
 		})
 	}
 	return g.Wait()
@@ -95,6 +128,8 @@ func parseFlags() models.Input {
 	apmServerSecret := flag.String("apm-secret", "", "apm server secret token") // ELASTIC_APM_SECRET_TOKEN
 	apmServerAPIKey := flag.String("api-key", "", "APM API yey")
 	apmServerUrl := flag.String("apm-url", "http://localhost:8200", "apm server url") // ELASTIC_APM_SERVER_URL
+	protocol := flag.String("protocol", string(models.ProtocolElasticAPM),
+		"payload protocol to speak to apm-url: elastic-apm, otlp-http or otlp-grpc")
 
 	elasticsearchUrl := flag.String("es-url", "http://localhost:9200", "elasticsearch url for reporting")
 	elasticsearchAuth := flag.String("es-auth", "", "elasticsearch username:password reporting")
@@ -106,17 +141,32 @@ func parseFlags() models.Input {
 	regressionMargin := flag.Float64("rm", 1.1, "margin of acceptable performance decrease to not consider a regression (only in combination with -bench)")
 	regressionDays := flag.String("rd", "7", "number of days back to check for regressions (only in combination with -bench)")
 
+	configPath := flag.String("config", "", "path to a YAML or JSON config file; CLI flags override values it sets")
+	profile := flag.String("profile", "", "named profile to select from -config; "+
+		"in -bench mode, leaving this empty runs every profile the file declares")
+
+	metricsAddr := flag.String("metrics-addr", "", "bind address for a live /metrics and /debug/pprof HTTP server (disabled if empty, only if -bench is not passed)")
+	influxUrl := flag.String("influx-url", "", "InfluxDB write url to push live metrics to (disabled if empty, only if -bench is not passed)")
+	metricsInterval := flag.Duration("metrics-interval", 10*time.Second, "how often to push metrics to -influx-url")
+
 	// payload options
 	errorLimit := flag.Int("e", math.MaxInt64, "max errors to generate (only if -bench is not passed)")
-	errorFrequency := flag.Duration("ef", 1*time.Nanosecond, "error frequency. "+
-		"generate errors up to once in this duration (only if -bench is not passed)")
 	errorFrameMaxLimit := flag.Int("ex", 10, "max error frames to per error (only if -bench is not passed)")
 	errorFrameMinLimit := flag.Int("em", 0, "max error frames to per error (only if -bench is not passed)")
 	spanMaxLimit := flag.Int("sx", 10, "max spans to per transaction (only if -bench is not passed)")
 	spanMinLimit := flag.Int("sm", 1, "min spans to per transaction (only if -bench is not passed)")
 	transactionLimit := flag.Int("t", math.MaxInt64, "max transactions to generate (only if -bench is not passed)")
-	transactionFrequency := flag.Duration("tf", 1*time.Nanosecond, "transaction frequency. "+
-		"generate transactions up to once in this duration (only if -bench is not passed)")
+
+	// rate limiting options: -tf-rate/-tf-burst and -ef-rate/-ef-burst
+	// replace the old "up to once per duration" -tf/-ef flags with a
+	// token-bucket limiter shared across all -instances, so throughput
+	// is a precise, reproducible target rather than a jittered upper
+	// bound. A rate of 0 with a nonzero burst means "one-shot batch".
+	transactionRate := flag.Float64("tf-rate", 1000, "transaction rate limit, in transactions per second "+
+		"(only if -bench is not passed)")
+	transactionBurst := flag.Int("tf-burst", 1, "transaction rate limit burst size (only if -bench is not passed)")
+	errorRate := flag.Float64("ef-rate", 1000, "error rate limit, in errors per second (only if -bench is not passed)")
+	errorBurst := flag.Int("ef-burst", 1, "error rate limit burst size (only if -bench is not passed)")
 	flag.Parse()
 
 	if *spanMaxLimit < *spanMinLimit {
@@ -125,8 +175,15 @@ func parseFlags() models.Input {
 
 	rand.Seed(*seed)
 
+	switch models.Protocol(*protocol) {
+	case models.ProtocolElasticAPM, models.ProtocolOTLPHTTP, models.ProtocolOTLPGRPC:
+	default:
+		panic(fmt.Sprintf("unknown -protocol %q", *protocol))
+	}
+
 	input := models.Input{
 		IsBenchmark:          *isBench,
+		Protocol:             models.Protocol(*protocol),
 		ApmServerUrl:         *apmServerUrl,
 		ApmServerSecret:      *apmServerSecret,
 		APIKey:               *apmServerAPIKey,
@@ -139,6 +196,10 @@ func parseFlags() models.Input {
 		FlushTimeout:         *flushTimeout,
 		Instances:            *instances,
 		DelayMillis:          *delayMillis,
+		MetricsAddr:          *metricsAddr,
+		InfluxUrl:            *influxUrl,
+		MetricsInterval:      *metricsInterval,
+		RunID:                fmt.Sprintf("%x", rand.Int63()),
 	}
 
 	if *isBench {
@@ -147,17 +208,44 @@ func parseFlags() models.Input {
 		}
 		input.RegressionDays = *regressionDays
 		input.RegressionMargin = *regressionMargin
+		input.TransactionLimit = math.MaxInt64
+		input.ErrorLimit = math.MaxInt64
+		input.SpanMaxLimit = benchSpanMaxLimit
+		input.SpanMinLimit = benchSpanMinLimit
+		input.ErrorFrameMaxLimit = benchErrorFrameMaxLimit
+		input.ErrorFrameMinLimit = benchErrorFrameMinLimit
+		input.TransactionRate, input.TransactionBurst = benchTransactionRate, benchTransactionBurst
+		input.ErrorRate, input.ErrorBurst = benchErrorRate, benchErrorBurst
+		input.TransactionLimiter = rate.NewLimiter(rate.Limit(benchTransactionRate), benchTransactionBurst)
+		input.ErrorLimiter = rate.NewLimiter(rate.Limit(benchErrorRate), benchErrorBurst)
+	} else {
+		input.TransactionRate, input.TransactionBurst = *transactionRate, *transactionBurst
+		input.TransactionLimiter = rate.NewLimiter(rate.Limit(*transactionRate), *transactionBurst)
+		input.TransactionLimit = *transactionLimit
+		input.SpanMaxLimit = *spanMaxLimit
+		input.SpanMinLimit = *spanMinLimit
+		input.ErrorRate, input.ErrorBurst = *errorRate, *errorBurst
+		input.ErrorLimiter = rate.NewLimiter(rate.Limit(*errorRate), *errorBurst)
+		input.ErrorLimit = *errorLimit
+		input.ErrorFrameMaxLimit = *errorFrameMaxLimit
+		input.ErrorFrameMinLimit = *errorFrameMinLimit
+	}
+
+	if *configPath == "" {
 		return input
 	}
 
-	input.TransactionFrequency = *transactionFrequency
-	input.TransactionLimit = *transactionLimit
-	input.SpanMaxLimit = *spanMaxLimit
-	input.SpanMinLimit = *spanMinLimit
-	input.ErrorFrequency = *errorFrequency
-	input.ErrorLimit = *errorLimit
-	input.ErrorFrameMaxLimit = *errorFrameMaxLimit
-	input.ErrorFrameMinLimit = *errorFrameMinLimit
+	fileInput, err := models.LoadConfig(*configPath, *profile, input)
+	if err != nil {
+		panic(err)
+	}
 
-	return input
+	explicitlySet := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicitlySet[f.Name] = true })
+	merged := models.MergeCLIOverFile(input, fileInput, explicitlySet)
+	// Stash the raw CLI Input and which flags were explicitly passed, so
+	// benchmark.Run can reapply the same overrides to every profile when
+	// -config declares any profiles and -profile was left empty.
+	merged.CLIOverrides = &models.CLIOverrides{Input: input, ExplicitlySet: explicitlySet}
+	return merged
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/elastic/hey-apm/models"
+	"github.com/elastic/hey-apm/worker"
+)
+
+// startMetricsServer binds addr and serves Prometheus-format counters
+// under /metrics plus net/http/pprof's profiling endpoints under
+// /debug/pprof, so a long-running soak test can be inspected live
+// instead of only summarized once it stops. It's a no-op when addr is
+// empty, which is the default.
+func startMetricsServer(addr string, instances []*worker.Counters) error {
+	if addr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(renderPrometheus(instances))
+	})
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("metrics server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+func renderPrometheus(instances []*worker.Counters) []byte {
+	var transactions, errors, bytesSent int64
+	for _, c := range instances {
+		transactions += c.TransactionsSent()
+		errors += c.ErrorsSent()
+		bytesSent += c.BytesSent()
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# HELP hey_apm_transactions_sent_total Transactions sent to apm-url.\n")
+	fmt.Fprintf(&buf, "# TYPE hey_apm_transactions_sent_total counter\n")
+	fmt.Fprintf(&buf, "hey_apm_transactions_sent_total %d\n", transactions)
+
+	fmt.Fprintf(&buf, "# HELP hey_apm_errors_sent_total Errors sent to apm-url.\n")
+	fmt.Fprintf(&buf, "# TYPE hey_apm_errors_sent_total counter\n")
+	fmt.Fprintf(&buf, "hey_apm_errors_sent_total %d\n", errors)
+
+	fmt.Fprintf(&buf, "# HELP hey_apm_bytes_sent_total Approximate payload bytes sent to apm-url.\n")
+	fmt.Fprintf(&buf, "# TYPE hey_apm_bytes_sent_total counter\n")
+	fmt.Fprintf(&buf, "hey_apm_bytes_sent_total %d\n", bytesSent)
+
+	// In-flight requests are exposed per instance, labeled rather than
+	// summed, since what a soak test operator actually wants to see is
+	// whether one particular worker is stalled, not a fleet-wide total.
+	fmt.Fprintf(&buf, "# HELP hey_apm_requests_in_flight In-flight requests for one worker instance.\n")
+	fmt.Fprintf(&buf, "# TYPE hey_apm_requests_in_flight gauge\n")
+	for idx, c := range instances {
+		fmt.Fprintf(&buf, "hey_apm_requests_in_flight{instance=\"%d\"} %d\n", idx, c.InFlight())
+	}
+
+	// Flush latency is a real cumulative histogram (bucket/sum/count),
+	// per instance, rather than one gauge sampling whichever worker
+	// last happened to flush.
+	fmt.Fprintf(&buf, "# HELP hey_apm_flush_latency_seconds Duration of agent flushes.\n")
+	fmt.Fprintf(&buf, "# TYPE hey_apm_flush_latency_seconds histogram\n")
+	for idx, c := range instances {
+		bucketCounts, count, sum := c.FlushLatencyHistogram()
+		for i, bound := range worker.FlushLatencyBuckets {
+			fmt.Fprintf(&buf, "hey_apm_flush_latency_seconds_bucket{instance=\"%d\",le=\"%g\"} %d\n", idx, bound, bucketCounts[i])
+		}
+		fmt.Fprintf(&buf, "hey_apm_flush_latency_seconds_bucket{instance=\"%d\",le=\"+Inf\"} %d\n", idx, count)
+		fmt.Fprintf(&buf, "hey_apm_flush_latency_seconds_sum{instance=\"%d\"} %f\n", idx, sum.Seconds())
+		fmt.Fprintf(&buf, "hey_apm_flush_latency_seconds_count{instance=\"%d\"} %d\n", idx, count)
+	}
+
+	return buf.Bytes()
+}
+
+// pushInflux writes the same counters renderPrometheus exposes as
+// InfluxDB line protocol to input.InfluxUrl every input.MetricsInterval,
+// one point per instance, until ctx is cancelled. It's started as a
+// goroutine from runWorkers only when input.InfluxUrl is set.
+func pushInflux(ctx context.Context, input models.Input, instances []*worker.Counters) {
+	interval := input.MetricsInterval
+	if interval <= 0 {
+		log.Printf("metrics-interval %v is not positive, defaulting to 10s for influx export", interval)
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := writeInfluxPoints(ctx, input, instances); err != nil {
+				log.Printf("influx export failed: %v", err)
+			}
+		}
+	}
+}
+
+func writeInfluxPoints(ctx context.Context, input models.Input, instances []*worker.Counters) error {
+	var buf bytes.Buffer
+	now := time.Now().UnixNano()
+	for idx, c := range instances {
+		fmt.Fprintf(&buf,
+			"hey_apm,service_name=%s,instance=%d,run=%s transactions_sent=%di,errors_sent=%di,bytes_sent=%di,in_flight=%di,flush_latency_seconds=%f %d\n",
+			input.ServiceName, idx, input.RunID,
+			c.TransactionsSent(), c.ErrorsSent(), c.BytesSent(), c.InFlight(), c.FlushLatency().Seconds(), now)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, input.InfluxUrl, &buf)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("influx write returned %s", resp.Status)
+	}
+	return nil
+}
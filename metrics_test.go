@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/elastic/hey-apm/worker"
+)
+
+// TestRenderPrometheusLabelsByInstance guards against in-flight and
+// flush-latency metrics being summed/last-writer-wins across
+// instances instead of reported per worker, which is what the
+// -metrics-addr endpoint is supposed to let an operator distinguish
+// (e.g. "is instance 2 stalled").
+func TestRenderPrometheusLabelsByInstance(t *testing.T) {
+	instances := []*worker.Counters{{}, {}}
+
+	out := string(renderPrometheus(instances))
+
+	if !strings.Contains(out, `hey_apm_requests_in_flight{instance="0"}`) {
+		t.Errorf("missing per-instance in-flight gauge for instance 0:\n%s", out)
+	}
+	if !strings.Contains(out, `hey_apm_requests_in_flight{instance="1"}`) {
+		t.Errorf("missing per-instance in-flight gauge for instance 1:\n%s", out)
+	}
+	if !strings.Contains(out, "# TYPE hey_apm_flush_latency_seconds histogram") {
+		t.Errorf("flush latency should be exposed as a histogram, not a gauge:\n%s", out)
+	}
+	if !strings.Contains(out, `hey_apm_flush_latency_seconds_bucket{instance="0",le="+Inf"}`) {
+		t.Errorf("missing per-instance flush latency histogram bucket for instance 0:\n%s", out)
+	}
+	if !strings.Contains(out, `hey_apm_flush_latency_seconds_bucket{instance="1",le="+Inf"}`) {
+		t.Errorf("missing per-instance flush latency histogram bucket for instance 1:\n%s", out)
+	}
+}
@@ -0,0 +1,431 @@
+package models
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v2"
+
+	"encoding/json"
+)
+
+// fileInput mirrors Input but with every field optional, so a config
+// file (or a profile within one) only needs to specify the values it
+// wants to pin; anything left nil falls back to the surrounding
+// defaults or, ultimately, to the flag defaults in parseFlags.
+type fileInput struct {
+	IsBenchmark *bool   `yaml:"bench" json:"bench"`
+	Protocol    *string `yaml:"protocol" json:"protocol"`
+
+	ApmServerUrl    *string `yaml:"apm-url" json:"apm-url"`
+	ApmServerSecret *string `yaml:"apm-secret" json:"apm-secret"`
+	APIKey          *string `yaml:"api-key" json:"api-key"`
+
+	ElasticsearchUrl  *string `yaml:"es-url" json:"es-url"`
+	ElasticsearchAuth *string `yaml:"es-auth" json:"es-auth"`
+
+	ApmElasticsearchUrl  *string `yaml:"apm-es-url" json:"apm-es-url"`
+	ApmElasticsearchAuth *string `yaml:"apm-es-auth" json:"apm-es-auth"`
+
+	ServiceName *string `yaml:"service-name" json:"service-name"`
+
+	RunTimeout   *string `yaml:"run" json:"run"`
+	FlushTimeout *string `yaml:"flush" json:"flush"`
+	Instances    *int    `yaml:"instances" json:"instances"`
+	DelayMillis  *int    `yaml:"delay" json:"delay"`
+
+	RegressionMargin *float64 `yaml:"rm" json:"rm"`
+	RegressionDays   *string  `yaml:"rd" json:"rd"`
+
+	TransactionLimit   *int `yaml:"t" json:"t"`
+	SpanMaxLimit       *int `yaml:"sx" json:"sx"`
+	SpanMinLimit       *int `yaml:"sm" json:"sm"`
+	ErrorLimit         *int `yaml:"e" json:"e"`
+	ErrorFrameMaxLimit *int `yaml:"ex" json:"ex"`
+	ErrorFrameMinLimit *int `yaml:"em" json:"em"`
+
+	TransactionRate  *float64 `yaml:"tf-rate" json:"tf-rate"`
+	TransactionBurst *int     `yaml:"tf-burst" json:"tf-burst"`
+	ErrorRate        *float64 `yaml:"ef-rate" json:"ef-rate"`
+	ErrorBurst       *int     `yaml:"ef-burst" json:"ef-burst"`
+
+	MetricsAddr     *string `yaml:"metrics-addr" json:"metrics-addr"`
+	InfluxUrl       *string `yaml:"influx-url" json:"influx-url"`
+	MetricsInterval *string `yaml:"metrics-interval" json:"metrics-interval"`
+}
+
+// configFile is the top-level shape of a -config file: a set of
+// defaults, plus any number of named profiles that override them.
+type configFile struct {
+	fileInput `yaml:",inline"`
+	Profiles  map[string]fileInput `yaml:"profiles" json:"profiles"`
+}
+
+// LoadConfig reads path (YAML or JSON, chosen by file extension) and
+// returns the Input it describes. base is normally the Input parseFlags
+// already built from CLI flag defaults: any field the file (or its
+// selected profile) doesn't set falls through to base rather than to
+// Go's zero value, so a profile only needs to specify the values it
+// wants to pin. If profile is non-empty, the named entry under
+// "profiles:" is merged on top of the file's top-level defaults;
+// profile must exist in the file or LoadConfig returns an error. The
+// returned Input still needs any CLI flags that were explicitly passed
+// applied on top, which parseFlags does via MergeCLIOverFile.
+func LoadConfig(path, profile string, base Input) (Input, error) {
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return Input{}, err
+	}
+
+	merged := cfg.fileInput
+	if profile != "" {
+		p, ok := cfg.Profiles[profile]
+		if !ok {
+			return Input{}, fmt.Errorf("profile %q not found in %s", profile, path)
+		}
+		merged = mergeFileInput(merged, p)
+	}
+
+	input, err := merged.toInput(base)
+	if err != nil {
+		return Input{}, err
+	}
+	input.ConfigPath = path
+	input.Profile = profile
+	if err := validate(input); err != nil {
+		return Input{}, err
+	}
+	return input, nil
+}
+
+// ListProfiles returns the names of the profiles declared in path, in
+// no particular order. It's used by benchmark.Run to fan out over
+// every profile when -profile wasn't given.
+func ListProfiles(path string) ([]string, error) {
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func readConfigFile(path string) (configFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return configFile{}, err
+	}
+
+	var cfg configFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &cfg)
+	case ".json":
+		err = json.Unmarshal(data, &cfg)
+	default:
+		return configFile{}, fmt.Errorf("unsupported config extension %q (want .yaml, .yml or .json)", filepath.Ext(path))
+	}
+	if err != nil {
+		return configFile{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// mergeFileInput overlays override on top of base, field by field.
+func mergeFileInput(base, override fileInput) fileInput {
+	if override.IsBenchmark != nil {
+		base.IsBenchmark = override.IsBenchmark
+	}
+	if override.Protocol != nil {
+		base.Protocol = override.Protocol
+	}
+	if override.ApmServerUrl != nil {
+		base.ApmServerUrl = override.ApmServerUrl
+	}
+	if override.ApmServerSecret != nil {
+		base.ApmServerSecret = override.ApmServerSecret
+	}
+	if override.APIKey != nil {
+		base.APIKey = override.APIKey
+	}
+	if override.ElasticsearchUrl != nil {
+		base.ElasticsearchUrl = override.ElasticsearchUrl
+	}
+	if override.ElasticsearchAuth != nil {
+		base.ElasticsearchAuth = override.ElasticsearchAuth
+	}
+	if override.ApmElasticsearchUrl != nil {
+		base.ApmElasticsearchUrl = override.ApmElasticsearchUrl
+	}
+	if override.ApmElasticsearchAuth != nil {
+		base.ApmElasticsearchAuth = override.ApmElasticsearchAuth
+	}
+	if override.ServiceName != nil {
+		base.ServiceName = override.ServiceName
+	}
+	if override.RunTimeout != nil {
+		base.RunTimeout = override.RunTimeout
+	}
+	if override.FlushTimeout != nil {
+		base.FlushTimeout = override.FlushTimeout
+	}
+	if override.Instances != nil {
+		base.Instances = override.Instances
+	}
+	if override.DelayMillis != nil {
+		base.DelayMillis = override.DelayMillis
+	}
+	if override.RegressionMargin != nil {
+		base.RegressionMargin = override.RegressionMargin
+	}
+	if override.RegressionDays != nil {
+		base.RegressionDays = override.RegressionDays
+	}
+	if override.TransactionLimit != nil {
+		base.TransactionLimit = override.TransactionLimit
+	}
+	if override.SpanMaxLimit != nil {
+		base.SpanMaxLimit = override.SpanMaxLimit
+	}
+	if override.SpanMinLimit != nil {
+		base.SpanMinLimit = override.SpanMinLimit
+	}
+	if override.ErrorLimit != nil {
+		base.ErrorLimit = override.ErrorLimit
+	}
+	if override.ErrorFrameMaxLimit != nil {
+		base.ErrorFrameMaxLimit = override.ErrorFrameMaxLimit
+	}
+	if override.ErrorFrameMinLimit != nil {
+		base.ErrorFrameMinLimit = override.ErrorFrameMinLimit
+	}
+	if override.TransactionRate != nil {
+		base.TransactionRate = override.TransactionRate
+	}
+	if override.TransactionBurst != nil {
+		base.TransactionBurst = override.TransactionBurst
+	}
+	if override.ErrorRate != nil {
+		base.ErrorRate = override.ErrorRate
+	}
+	if override.ErrorBurst != nil {
+		base.ErrorBurst = override.ErrorBurst
+	}
+	if override.MetricsAddr != nil {
+		base.MetricsAddr = override.MetricsAddr
+	}
+	if override.InfluxUrl != nil {
+		base.InfluxUrl = override.InfluxUrl
+	}
+	if override.MetricsInterval != nil {
+		base.MetricsInterval = override.MetricsInterval
+	}
+	return base
+}
+
+// toInput overlays the fields f sets onto base, which is normally the
+// Input already built from CLI flag defaults (see parseFlags). Fields
+// f leaves nil fall through to base unchanged, so a profile that only
+// pins tf-rate/tf-burst doesn't collapse every other field - Instances,
+// RunTimeout, the payload limits, ... - to Go's zero value.
+func (f fileInput) toInput(base Input) (Input, error) {
+	input := base
+	if f.IsBenchmark != nil {
+		input.IsBenchmark = *f.IsBenchmark
+	}
+	if f.Protocol != nil {
+		switch p := Protocol(*f.Protocol); p {
+		case ProtocolElasticAPM, ProtocolOTLPHTTP, ProtocolOTLPGRPC:
+			input.Protocol = p
+		default:
+			return Input{}, fmt.Errorf("protocol: unknown %q (want %s, %s or %s)", *f.Protocol, ProtocolElasticAPM, ProtocolOTLPHTTP, ProtocolOTLPGRPC)
+		}
+	}
+	if f.ApmServerUrl != nil {
+		input.ApmServerUrl = *f.ApmServerUrl
+	}
+	if f.ApmServerSecret != nil {
+		input.ApmServerSecret = *f.ApmServerSecret
+	}
+	if f.APIKey != nil {
+		input.APIKey = *f.APIKey
+	}
+	if f.ElasticsearchUrl != nil {
+		input.ElasticsearchUrl = *f.ElasticsearchUrl
+	}
+	if f.ElasticsearchAuth != nil {
+		input.ElasticsearchAuth = *f.ElasticsearchAuth
+	}
+	if f.ApmElasticsearchUrl != nil {
+		input.ApmElasticsearchUrl = *f.ApmElasticsearchUrl
+	}
+	if f.ApmElasticsearchAuth != nil {
+		input.ApmElasticsearchAuth = *f.ApmElasticsearchAuth
+	}
+	if f.ServiceName != nil {
+		input.ServiceName = *f.ServiceName
+	}
+	if f.RunTimeout != nil {
+		d, err := time.ParseDuration(*f.RunTimeout)
+		if err != nil {
+			return Input{}, fmt.Errorf("run: %w", err)
+		}
+		input.RunTimeout = d
+	}
+	if f.FlushTimeout != nil {
+		d, err := time.ParseDuration(*f.FlushTimeout)
+		if err != nil {
+			return Input{}, fmt.Errorf("flush: %w", err)
+		}
+		input.FlushTimeout = d
+	}
+	if f.Instances != nil {
+		input.Instances = *f.Instances
+	}
+	if f.DelayMillis != nil {
+		input.DelayMillis = *f.DelayMillis
+	}
+	if f.RegressionMargin != nil {
+		input.RegressionMargin = *f.RegressionMargin
+	}
+	if f.RegressionDays != nil {
+		input.RegressionDays = *f.RegressionDays
+	}
+	if f.TransactionLimit != nil {
+		input.TransactionLimit = *f.TransactionLimit
+	}
+	if f.SpanMaxLimit != nil {
+		input.SpanMaxLimit = *f.SpanMaxLimit
+	}
+	if f.SpanMinLimit != nil {
+		input.SpanMinLimit = *f.SpanMinLimit
+	}
+	if f.ErrorLimit != nil {
+		input.ErrorLimit = *f.ErrorLimit
+	}
+	if f.ErrorFrameMaxLimit != nil {
+		input.ErrorFrameMaxLimit = *f.ErrorFrameMaxLimit
+	}
+	if f.ErrorFrameMinLimit != nil {
+		input.ErrorFrameMinLimit = *f.ErrorFrameMinLimit
+	}
+
+	if f.TransactionRate != nil {
+		input.TransactionRate = *f.TransactionRate
+	}
+	if f.TransactionBurst != nil {
+		input.TransactionBurst = *f.TransactionBurst
+	}
+	if f.ErrorRate != nil {
+		input.ErrorRate = *f.ErrorRate
+	}
+	if f.ErrorBurst != nil {
+		input.ErrorBurst = *f.ErrorBurst
+	}
+	input.TransactionLimiter = rate.NewLimiter(rate.Limit(input.TransactionRate), input.TransactionBurst)
+	input.ErrorLimiter = rate.NewLimiter(rate.Limit(input.ErrorRate), input.ErrorBurst)
+
+	if f.MetricsAddr != nil {
+		input.MetricsAddr = *f.MetricsAddr
+	}
+	if f.InfluxUrl != nil {
+		input.InfluxUrl = *f.InfluxUrl
+	}
+	if f.MetricsInterval != nil {
+		d, err := time.ParseDuration(*f.MetricsInterval)
+		if err != nil {
+			return Input{}, fmt.Errorf("metrics-interval: %w", err)
+		}
+		input.MetricsInterval = d
+	}
+
+	// Apply the sx/sm clamp parseFlags applies to the CLI flags, and the
+	// same clamp to ex/em: both pairs are a [min, max] range, and
+	// rand.Intn panics on a non-positive argument if max ends up below
+	// min.
+	if input.SpanMaxLimit < input.SpanMinLimit {
+		input.SpanMaxLimit = input.SpanMinLimit
+	}
+	if input.ErrorFrameMaxLimit < input.ErrorFrameMinLimit {
+		input.ErrorFrameMaxLimit = input.ErrorFrameMinLimit
+	}
+
+	return input, nil
+}
+
+// MergeCLIOverFile combines a config-file Input with the Input built
+// from CLI flags: for each flag name in explicitlySet, the CLI value
+// wins; everything else falls back to the file's value. This is how
+// "-config path" + a handful of explicit flags is supposed to behave:
+// the file supplies the baseline, the flags the user actually typed
+// override it.
+func MergeCLIOverFile(cli, file Input, explicitlySet map[string]bool) Input {
+	merged := file
+
+	set := func(name string, apply func()) {
+		if explicitlySet[name] {
+			apply()
+		}
+	}
+
+	set("bench", func() { merged.IsBenchmark = cli.IsBenchmark })
+	set("protocol", func() { merged.Protocol = cli.Protocol })
+	set("apm-url", func() { merged.ApmServerUrl = cli.ApmServerUrl })
+	set("apm-secret", func() { merged.ApmServerSecret = cli.ApmServerSecret })
+	set("api-key", func() { merged.APIKey = cli.APIKey })
+	set("es-url", func() { merged.ElasticsearchUrl = cli.ElasticsearchUrl })
+	set("es-auth", func() { merged.ElasticsearchAuth = cli.ElasticsearchAuth })
+	set("apm-es-url", func() { merged.ApmElasticsearchUrl = cli.ApmElasticsearchUrl })
+	set("apm-es-auth", func() { merged.ApmElasticsearchAuth = cli.ApmElasticsearchAuth })
+	set("service-name", func() { merged.ServiceName = cli.ServiceName })
+	set("run", func() { merged.RunTimeout = cli.RunTimeout })
+	set("flush", func() { merged.FlushTimeout = cli.FlushTimeout })
+	set("instances", func() { merged.Instances = cli.Instances })
+	set("delay", func() { merged.DelayMillis = cli.DelayMillis })
+	set("rm", func() { merged.RegressionMargin = cli.RegressionMargin })
+	set("rd", func() { merged.RegressionDays = cli.RegressionDays })
+	set("t", func() { merged.TransactionLimit = cli.TransactionLimit })
+	set("sx", func() { merged.SpanMaxLimit = cli.SpanMaxLimit })
+	set("sm", func() { merged.SpanMinLimit = cli.SpanMinLimit })
+	set("e", func() { merged.ErrorLimit = cli.ErrorLimit })
+	set("ex", func() { merged.ErrorFrameMaxLimit = cli.ErrorFrameMaxLimit })
+	set("em", func() { merged.ErrorFrameMinLimit = cli.ErrorFrameMinLimit })
+	set("tf-rate", func() { merged.TransactionRate = cli.TransactionRate })
+	set("tf-burst", func() { merged.TransactionBurst = cli.TransactionBurst })
+	set("ef-rate", func() { merged.ErrorRate = cli.ErrorRate })
+	set("ef-burst", func() { merged.ErrorBurst = cli.ErrorBurst })
+	set("metrics-addr", func() { merged.MetricsAddr = cli.MetricsAddr })
+	set("influx-url", func() { merged.InfluxUrl = cli.InfluxUrl })
+	set("metrics-interval", func() { merged.MetricsInterval = cli.MetricsInterval })
+
+	// Rebuild the limiters from the merged rate/burst scalars rather
+	// than taking either side's pre-built *rate.Limiter wholesale, so
+	// overriding just -tf-rate (say) doesn't silently reset the burst
+	// the file pinned back to the CLI default, or vice versa.
+	merged.TransactionLimiter = rate.NewLimiter(rate.Limit(merged.TransactionRate), merged.TransactionBurst)
+	merged.ErrorLimiter = rate.NewLimiter(rate.Limit(merged.ErrorRate), merged.ErrorBurst)
+
+	merged.ConfigPath = file.ConfigPath
+	merged.Profile = file.Profile
+	return merged
+}
+
+// validate rejects configs that set bench-only fields without also
+// turning bench mode on, rather than silently ignoring them. Payload
+// shape fields (t/e/sx/sm/ex/em) are legal in both modes - bench mode
+// needs them too, to pin a reproducible transaction/error shape per
+// profile - so only the regression-detection fields are bench-only.
+func validate(input Input) error {
+	benchOnlySet := input.RegressionDays != "" || input.RegressionMargin != 0
+	if !input.IsBenchmark && benchOnlySet {
+		return fmt.Errorf("config sets bench-only fields (rm/rd) without bench: true")
+	}
+	return nil
+}
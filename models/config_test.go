@@ -0,0 +1,153 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMergeCLIOverFileKeepsBurstWhenOnlyRateIsSet guards against a flag
+// explicitly set on the CLI (-tf-rate) silently resetting a sibling value
+// (burst) that was only ever pinned in the config file, back to the CLI's
+// flag default.
+func TestMergeCLIOverFileKeepsBurstWhenOnlyRateIsSet(t *testing.T) {
+	cli := Input{TransactionRate: 1000, TransactionBurst: 1, ErrorRate: 1000, ErrorBurst: 1}
+	file := Input{TransactionRate: 50, TransactionBurst: 20, ErrorRate: 5, ErrorBurst: 2}
+
+	merged := MergeCLIOverFile(cli, file, map[string]bool{"tf-rate": true})
+
+	if merged.TransactionRate != cli.TransactionRate {
+		t.Errorf("TransactionRate = %v, want the explicitly-set CLI value %v", merged.TransactionRate, cli.TransactionRate)
+	}
+	if merged.TransactionBurst != file.TransactionBurst {
+		t.Errorf("TransactionBurst = %v, want the file's value %v to survive since -tf-burst wasn't set", merged.TransactionBurst, file.TransactionBurst)
+	}
+	if merged.TransactionLimiter.Burst() != file.TransactionBurst {
+		t.Errorf("TransactionLimiter burst = %v, want %v", merged.TransactionLimiter.Burst(), file.TransactionBurst)
+	}
+}
+
+// TestLoadConfigInheritsUnsetFieldsFromBase guards against a -config
+// profile that only pins a couple of fields collapsing every other
+// field - Instances, RunTimeout, the payload limits, ... - to Go's
+// zero value instead of the CLI flag defaults. Before this test, a
+// profile like the one below left Instances at 0, which made
+// runWorkers spawn zero goroutines and return immediately: -config
+// turned the run into a silent no-op.
+func TestLoadConfigInheritsUnsetFieldsFromBase(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	contents := "apm-url: http://apm-under-test:8200\n" +
+		"profiles:\n" +
+		"  small-bursty:\n" +
+		"    tf-rate: 50\n" +
+		"    tf-burst: 20\n"
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	base := Input{
+		Instances:        4,
+		RunTimeout:       30_000_000_000,
+		TransactionLimit: 1000,
+		ErrorLimit:       100,
+	}
+
+	input, err := LoadConfig(path, "small-bursty", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if input.Instances != base.Instances {
+		t.Errorf("Instances = %v, want inherited base value %v", input.Instances, base.Instances)
+	}
+	if input.RunTimeout != base.RunTimeout {
+		t.Errorf("RunTimeout = %v, want inherited base value %v", input.RunTimeout, base.RunTimeout)
+	}
+	if input.TransactionLimit != base.TransactionLimit {
+		t.Errorf("TransactionLimit = %v, want inherited base value %v", input.TransactionLimit, base.TransactionLimit)
+	}
+	if input.ErrorLimit != base.ErrorLimit {
+		t.Errorf("ErrorLimit = %v, want inherited base value %v", input.ErrorLimit, base.ErrorLimit)
+	}
+	if input.ApmServerUrl != "http://apm-under-test:8200" {
+		t.Errorf("ApmServerUrl = %q, want the file's top-level value", input.ApmServerUrl)
+	}
+	if input.TransactionRate != 50 || input.TransactionBurst != 20 {
+		t.Errorf("TransactionRate/Burst = %v/%v, want the profile's 50/20", input.TransactionRate, input.TransactionBurst)
+	}
+
+	// MergeCLIOverFile must preserve that inheritance too, not just
+	// toInput: it's the function parseFlags and benchmark.Run actually
+	// call after LoadConfig.
+	merged := MergeCLIOverFile(base, input, map[string]bool{})
+	if merged.Instances != base.Instances {
+		t.Errorf("after MergeCLIOverFile, Instances = %v, want inherited base value %v", merged.Instances, base.Instances)
+	}
+}
+
+// TestLoadConfigClampsSpanAndErrorFrameLimits guards against a profile
+// that sets sx < sm (or ex < em) passing validate() silently and then
+// panicking the first time a transaction/error is generated, since
+// rand.Intn is called with the non-positive (max-min+1) result.
+// parseFlags clamps sx/sm for CLI flags; LoadConfig clamps both pairs.
+func TestLoadConfigClampsSpanAndErrorFrameLimits(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	contents := "sx: 1\nsm: 10\nex: 0\nem: 5\n"
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	input, err := LoadConfig(path, "", Input{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if input.SpanMaxLimit < input.SpanMinLimit {
+		t.Errorf("SpanMaxLimit %v < SpanMinLimit %v, want clamped", input.SpanMaxLimit, input.SpanMinLimit)
+	}
+	if input.ErrorFrameMaxLimit < input.ErrorFrameMinLimit {
+		t.Errorf("ErrorFrameMaxLimit %v < ErrorFrameMinLimit %v, want clamped", input.ErrorFrameMaxLimit, input.ErrorFrameMinLimit)
+	}
+}
+
+// TestLoadConfigRejectsUnknownProtocol guards against a typo'd
+// protocol: value in a -config file silently falling through
+// worker.Run's switch to the native elastic-apm sender instead of
+// failing loudly, the way parseFlags already does for a bad -protocol
+// CLI flag.
+func TestLoadConfigRejectsUnknownProtocol(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("protocol: otlp-htpp\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfig(path, "", Input{}); err == nil {
+		t.Fatal("LoadConfig did not reject an unknown protocol value")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   Input
+		wantErr bool
+	}{
+		{"non-bench with no bench-only fields", Input{}, false},
+		{"bench with payload-shape fields", Input{IsBenchmark: true, TransactionLimit: 100, ErrorLimit: 10}, false},
+		{"non-bench with payload-shape fields", Input{TransactionLimit: 100, ErrorLimit: 10}, false},
+		{"non-bench with regression days set", Input{RegressionDays: "7"}, true},
+		{"non-bench with regression margin set", Input{RegressionMargin: 1.1}, true},
+		{"bench with regression fields set", Input{IsBenchmark: true, RegressionDays: "7", RegressionMargin: 1.1}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validate(c.input)
+			if (err != nil) != c.wantErr {
+				t.Errorf("validate(%+v) error = %v, wantErr %v", c.input, err, c.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Package models holds the data shapes shared between the CLI flag
+// parsing, the worker load generator and the benchmark runner.
+package models
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Protocol selects the wire format and ingest endpoint used to send
+// generated load to ApmServerUrl.
+type Protocol string
+
+const (
+	// ProtocolElasticAPM sends data using the native Elastic APM agent
+	// intake protocol. This is the default and historical behavior.
+	ProtocolElasticAPM Protocol = "elastic-apm"
+	// ProtocolOTLPHTTP sends OTLP/protobuf payloads over HTTP to
+	// /v1/traces and /v1/logs.
+	ProtocolOTLPHTTP Protocol = "otlp-http"
+	// ProtocolOTLPGRPC streams OTLP payloads over gRPC.
+	ProtocolOTLPGRPC Protocol = "otlp-grpc"
+)
+
+// Input collects everything needed to drive a run of hey-apm, whether
+// it's ad-hoc load generation or a fixed benchmark.
+type Input struct {
+	IsBenchmark bool
+
+	// Protocol selects which of the above wire formats is used to talk
+	// to ApmServerUrl.
+	Protocol Protocol
+
+	// apm-server connection options
+	ApmServerUrl    string
+	ApmServerSecret string
+	APIKey          string
+
+	// elasticsearch reporting options
+	ElasticsearchUrl  string
+	ElasticsearchAuth string
+
+	// elasticsearch output of the apm-server under load
+	ApmElasticsearchUrl  string
+	ApmElasticsearchAuth string
+
+	ServiceName string
+
+	RunTimeout   time.Duration
+	FlushTimeout time.Duration
+	Instances    int
+	DelayMillis  int
+
+	RegressionMargin float64
+	RegressionDays   string
+
+	// payload shape options
+	TransactionLimit   int
+	SpanMaxLimit       int
+	SpanMinLimit       int
+	ErrorLimit         int
+	ErrorFrameMaxLimit int
+	ErrorFrameMinLimit int
+
+	// TransactionRate/TransactionBurst and ErrorRate/ErrorBurst are the
+	// raw -tf-rate/-tf-burst/-ef-rate/-ef-burst scalars. They're kept
+	// alongside the limiters built from them (rather than only as
+	// constructor arguments) so MergeCLIOverFile can override rate and
+	// burst independently - e.g. a CLI flag that only overrides rate
+	// shouldn't silently reset a burst pinned by a -config file.
+	TransactionRate  float64
+	TransactionBurst int
+	ErrorRate        float64
+	ErrorBurst       int
+
+	// TransactionLimiter and ErrorLimiter throttle the rate at which
+	// transactions and errors are generated. They are built once in
+	// parseFlags and shared across all Instances, so -instances splits
+	// a global throughput budget instead of multiplying it.
+	TransactionLimiter *rate.Limiter
+	ErrorLimiter       *rate.Limiter
+
+	// ConfigPath and Profile record where this Input came from, if it
+	// was loaded (in full or in part) from a -config file. Profile is
+	// empty when no specific profile was selected, which in -bench
+	// mode tells benchmark.Run to iterate every profile the file
+	// declares rather than run a single scenario.
+	ConfigPath string
+	Profile    string
+
+	// MetricsAddr, when non-empty, is the bind address for the live
+	// /metrics and /debug/pprof HTTP server started from Main.
+	MetricsAddr string
+	// InfluxUrl, when non-empty, is where live metrics are pushed as
+	// InfluxDB line protocol every MetricsInterval.
+	InfluxUrl       string
+	MetricsInterval time.Duration
+	// RunID tags every metrics sample pushed to InfluxUrl, so several
+	// concurrent hey-apm processes driving the same apm-server can be
+	// told apart in a dashboard.
+	RunID string
+
+	// CLIOverrides, when set, lets benchmark.Run reapply the same CLI
+	// flag overrides used to build this Input to every other profile
+	// in a -config file, when fanning out over all of them.
+	CLIOverrides *CLIOverrides
+}
+
+// CLIOverrides pairs the Input built purely from CLI flags with the
+// set of flag names that were explicitly passed, the two arguments
+// MergeCLIOverFile needs to redo the same merge against a different
+// profile's Input.
+type CLIOverrides struct {
+	Input         Input
+	ExplicitlySet map[string]bool
+}
@@ -0,0 +1,250 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+
+	"github.com/elastic/hey-apm/models"
+)
+
+// runOTLP generates load gated by the same -tf-rate/-ef-rate limiters
+// and -t/-e limits as runElasticAPM, but encodes it as OTLP
+// TracesData/LogsData protobuf messages and ships them to ApmServerUrl
+// over HTTP or gRPC. Each transaction is sent as a single server span
+// with no child spans: the -sx/-sm span count only sets that span's
+// duration (10ms per span). The -ex/-em frame count only appears in
+// the text of the error log record's body.
+func runOTLP(ctx context.Context, input models.Input, agentName string, stop <-chan struct{}, counters *Counters) error {
+	serviceName := input.ServiceName
+	if agentName != "" {
+		serviceName = agentName
+	}
+	resource := newResource(serviceName)
+
+	sender, err := newOTLPSender(input)
+	if err != nil {
+		return err
+	}
+	defer sender.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, input.RunTimeout)
+	defer cancel()
+
+	// Traces and logs are generated on independent goroutines, each
+	// gated only by its own limiter, for the same reason runElasticAPM
+	// does: a single loop waiting on both limiters in turn throttles
+	// the faster event type down to the slower one's rate.
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return runLimitedLoop(gctx, stop, input.TransactionLimiter, func() bool {
+			return counters.TransactionsSent() >= int64(input.TransactionLimit)
+		}, func() error {
+			span := randomSpan(input)
+			counters.beginRequest()
+			err := sender.SendTraces(gctx, resource, span)
+			counters.endRequest()
+			if err != nil {
+				return err
+			}
+			counters.addTransaction(proto.Size(span))
+			return nil
+		})
+	})
+	g.Go(func() error {
+		return runLimitedLoop(gctx, stop, input.ErrorLimiter, func() bool {
+			return counters.ErrorsSent() >= int64(input.ErrorLimit)
+		}, func() error {
+			logRecord := randomErrorLog(input)
+			counters.beginRequest()
+			err := sender.SendLogs(gctx, resource, logRecord)
+			counters.endRequest()
+			if err != nil {
+				return err
+			}
+			counters.addError(proto.Size(logRecord))
+			return nil
+		})
+	})
+	return g.Wait()
+}
+
+// otlpSender abstracts over the HTTP and gRPC transports so runOTLP
+// doesn't need to care which one was selected.
+type otlpSender interface {
+	SendTraces(ctx context.Context, resource *resourcepb.Resource, span *tracepb.Span) error
+	SendLogs(ctx context.Context, resource *resourcepb.Resource, record *logspb.LogRecord) error
+	Close() error
+}
+
+func newOTLPSender(input models.Input) (otlpSender, error) {
+	switch input.Protocol {
+	case models.ProtocolOTLPGRPC:
+		return newGRPCSender(input.ApmServerUrl)
+	default:
+		return &httpSender{
+			client:    &http.Client{Timeout: input.FlushTimeout},
+			serverURL: input.ApmServerUrl,
+		}, nil
+	}
+}
+
+type httpSender struct {
+	client    *http.Client
+	serverURL string
+}
+
+func (s *httpSender) SendTraces(ctx context.Context, resource *resourcepb.Resource, span *tracepb.Span) error {
+	req := &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{{
+			Resource: resource,
+			ScopeSpans: []*tracepb.ScopeSpans{{
+				Spans: []*tracepb.Span{span},
+			}},
+		}},
+	}
+	return s.post(ctx, "/v1/traces", req)
+}
+
+func (s *httpSender) SendLogs(ctx context.Context, resource *resourcepb.Resource, record *logspb.LogRecord) error {
+	req := &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: resource,
+			ScopeLogs: []*logspb.ScopeLogs{{
+				LogRecords: []*logspb.LogRecord{record},
+			}},
+		}},
+	}
+	return s.post(ctx, "/v1/logs", req)
+}
+
+func (s *httpSender) post(ctx context.Context, path string, msg proto.Message) error {
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.serverURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp-http: %s returned %s", path, resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSender) Close() error { return nil }
+
+type grpcSender struct {
+	conn        *grpc.ClientConn
+	traceClient coltracepb.TraceServiceClient
+	logsClient  collogspb.LogsServiceClient
+}
+
+func newGRPCSender(serverURL string) (*grpcSender, error) {
+	conn, err := grpc.Dial(grpcTarget(serverURL), grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	return &grpcSender{
+		conn:        conn,
+		traceClient: coltracepb.NewTraceServiceClient(conn),
+		logsClient:  collogspb.NewLogsServiceClient(conn),
+	}, nil
+}
+
+func (s *grpcSender) SendTraces(ctx context.Context, resource *resourcepb.Resource, span *tracepb.Span) error {
+	_, err := s.traceClient.Export(ctx, &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{{
+			Resource: resource,
+			ScopeSpans: []*tracepb.ScopeSpans{{
+				Spans: []*tracepb.Span{span},
+			}},
+		}},
+	})
+	return err
+}
+
+func (s *grpcSender) SendLogs(ctx context.Context, resource *resourcepb.Resource, record *logspb.LogRecord) error {
+	_, err := s.logsClient.Export(ctx, &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: resource,
+			ScopeLogs: []*logspb.ScopeLogs{{
+				LogRecords: []*logspb.LogRecord{record},
+			}},
+		}},
+	})
+	return err
+}
+
+func (s *grpcSender) Close() error { return s.conn.Close() }
+
+// grpcTarget strips any "http://"/"https://" scheme from serverURL:
+// grpc.Dial's target parser treats a "scheme://" prefix as a name
+// resolver (e.g. "dns://", "unix://"), not part of the address, and
+// fails to resolve "http"/"https" since neither is registered. -apm-url
+// defaults to "http://localhost:8200", so otlp-grpc mode needs the
+// bare "host:port" form of whatever was given.
+func grpcTarget(serverURL string) string {
+	if u, err := url.Parse(serverURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(serverURL, "https://"), "http://")
+}
+
+func newResource(serviceName string) *resourcepb.Resource {
+	return &resourcepb.Resource{
+		Attributes: []*commonpb.KeyValue{{
+			Key:   "service.name",
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: serviceName}},
+		}},
+	}
+}
+
+func randomSpan(input models.Input) *tracepb.Span {
+	now := time.Now()
+	spanCount := input.SpanMinLimit + rand.Intn(input.SpanMaxLimit-input.SpanMinLimit+1)
+	duration := time.Duration(spanCount*10) * time.Millisecond
+	return &tracepb.Span{
+		Name:              "GET /",
+		Kind:              tracepb.Span_SPAN_KIND_SERVER,
+		StartTimeUnixNano: uint64(now.UnixNano()),
+		EndTimeUnixNano:   uint64(now.Add(duration).UnixNano()),
+	}
+}
+
+func randomErrorLog(input models.Input) *logspb.LogRecord {
+	frameCount := input.ErrorFrameMinLimit + rand.Intn(input.ErrorFrameMaxLimit-input.ErrorFrameMinLimit+1)
+	return &logspb.LogRecord{
+		TimeUnixNano: uint64(time.Now().UnixNano()),
+		Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{
+			StringValue: fmt.Sprintf("synthetic error generated by hey-apm (%d frames)", frameCount),
+		}},
+		Attributes: []*commonpb.KeyValue{{
+			Key:   "event.name",
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "error"}},
+		}},
+	}
+}
@@ -0,0 +1,22 @@
+package worker
+
+import "testing"
+
+func TestGrpcTarget(t *testing.T) {
+	cases := []struct {
+		name      string
+		serverURL string
+		want      string
+	}{
+		{"http scheme stripped", "http://localhost:8200", "localhost:8200"},
+		{"https scheme stripped", "https://apm-server:8200", "apm-server:8200"},
+		{"bare host:port unchanged", "localhost:8200", "localhost:8200"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := grpcTarget(c.serverURL); got != c.want {
+				t.Errorf("grpcTarget(%q) = %q, want %q", c.serverURL, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// FlushLatencyBuckets are the upper bounds (in seconds) of the
+// cumulative histogram recordFlush feeds, in Prometheus's own default
+// bucket layout. They're exported so the metrics exporters can label
+// each bucket's "le" without duplicating the list.
+var FlushLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Stats exposes the live counters a Run accumulates. It exists so the
+// end-of-run summary printer and the metrics/InfluxDB exporters all
+// read from one source instead of keeping their own tallies.
+type Stats interface {
+	TransactionsSent() int64
+	ErrorsSent() int64
+	BytesSent() int64
+	InFlight() int64
+	FlushLatency() time.Duration
+
+	// FlushLatencyHistogram returns a snapshot of the cumulative flush
+	// latency histogram: one observation count per FlushLatencyBuckets
+	// bound (observations <= that bound), plus the total count and sum
+	// of every observed duration, in the order Prometheus's own
+	// histogram exposition format expects.
+	FlushLatencyHistogram() (bucketCounts []int64, count int64, sum time.Duration)
+}
+
+// Counters is the concrete, concurrency-safe Stats that Run updates as
+// it sends events. Callers construct one per instance and pass it in,
+// so it can be read concurrently while the run is still in progress.
+type Counters struct {
+	transactionsSent int64
+	errorsSent       int64
+	bytesSent        int64
+	inFlight         int64
+	flushLatencyNs   int64
+
+	flushLatencyBuckets [numFlushLatencyBuckets]int64
+	flushLatencyCount   int64
+	flushLatencySumNs   int64
+}
+
+// numFlushLatencyBuckets must match len(FlushLatencyBuckets); array
+// lengths need a constant, so it can't simply be derived from the var.
+const numFlushLatencyBuckets = 11
+
+func (c *Counters) TransactionsSent() int64 { return atomic.LoadInt64(&c.transactionsSent) }
+func (c *Counters) ErrorsSent() int64       { return atomic.LoadInt64(&c.errorsSent) }
+func (c *Counters) BytesSent() int64        { return atomic.LoadInt64(&c.bytesSent) }
+func (c *Counters) InFlight() int64         { return atomic.LoadInt64(&c.inFlight) }
+func (c *Counters) FlushLatency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.flushLatencyNs))
+}
+
+func (c *Counters) FlushLatencyHistogram() (bucketCounts []int64, count int64, sum time.Duration) {
+	bucketCounts = make([]int64, len(c.flushLatencyBuckets))
+	for i := range c.flushLatencyBuckets {
+		bucketCounts[i] = atomic.LoadInt64(&c.flushLatencyBuckets[i])
+	}
+	return bucketCounts, atomic.LoadInt64(&c.flushLatencyCount), time.Duration(atomic.LoadInt64(&c.flushLatencySumNs))
+}
+
+func (c *Counters) addTransaction(bytes int) {
+	atomic.AddInt64(&c.transactionsSent, 1)
+	atomic.AddInt64(&c.bytesSent, int64(bytes))
+}
+
+func (c *Counters) addError(bytes int) {
+	atomic.AddInt64(&c.errorsSent, 1)
+	atomic.AddInt64(&c.bytesSent, int64(bytes))
+}
+
+func (c *Counters) beginRequest() { atomic.AddInt64(&c.inFlight, 1) }
+func (c *Counters) endRequest()   { atomic.AddInt64(&c.inFlight, -1) }
+
+func (c *Counters) recordFlush(d time.Duration) {
+	atomic.StoreInt64(&c.flushLatencyNs, int64(d))
+	atomic.AddInt64(&c.flushLatencyCount, 1)
+	atomic.AddInt64(&c.flushLatencySumNs, int64(d))
+	for i, bound := range FlushLatencyBuckets {
+		if d.Seconds() <= bound {
+			atomic.AddInt64(&c.flushLatencyBuckets[i], 1)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCountersFlushLatencyHistogram guards against the flush latency
+// histogram only ever reflecting the last recorded sample: every
+// recordFlush call should land in every bucket whose bound it's <=,
+// and contribute to the overall count and sum.
+func TestCountersFlushLatencyHistogram(t *testing.T) {
+	var c Counters
+	c.recordFlush(3 * time.Millisecond)   // falls in every bucket >= 0.005s
+	c.recordFlush(200 * time.Millisecond) // falls in every bucket >= 0.25s
+
+	bucketCounts, count, sum := c.FlushLatencyHistogram()
+
+	if count != 2 {
+		t.Fatalf("count = %d, want 2", count)
+	}
+	if want := 203 * time.Millisecond; sum != want {
+		t.Fatalf("sum = %v, want %v", sum, want)
+	}
+	if len(bucketCounts) != len(FlushLatencyBuckets) {
+		t.Fatalf("len(bucketCounts) = %d, want %d", len(bucketCounts), len(FlushLatencyBuckets))
+	}
+	if bucketCounts[0] != 1 {
+		t.Errorf("bucket[0] (le=%g) = %d, want 1: only the 3ms sample falls in it", FlushLatencyBuckets[0], bucketCounts[0])
+	}
+	lastIdx := len(FlushLatencyBuckets) - 1
+	if bucketCounts[lastIdx] != 2 {
+		t.Errorf("bucket[%d] (le=%g) = %d, want 2: both samples fall in it", lastIdx, FlushLatencyBuckets[lastIdx], bucketCounts[lastIdx])
+	}
+}
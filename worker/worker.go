@@ -0,0 +1,193 @@
+// Package worker drives a single load-generating instance: it creates
+// transactions, spans and errors against an APM agent at whatever rate
+// the caller's Input allows, until the run timeout elapses or it is
+// asked to stop.
+package worker
+
+import (
+	"context"
+	"math/rand"
+	"net/url"
+	"time"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/transport"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+
+	"github.com/elastic/hey-apm/models"
+)
+
+// Run generates load against input.ApmServerUrl until input.RunTimeout
+// elapses, ctx is cancelled, or stop is closed, then flushes the agent
+// and returns. agentName, when non-empty, overrides input.ServiceName
+// for this instance; runWorkers and benchmark.Run both pass "".
+// counters is updated as events are sent so callers can read live
+// progress - e.g. the -metrics-addr server or the -influx-url exporter
+// - while the run is still in flight.
+func Run(ctx context.Context, input models.Input, agentName string, stop <-chan struct{}, counters *Counters) error {
+	switch input.Protocol {
+	case models.ProtocolOTLPHTTP, models.ProtocolOTLPGRPC:
+		return runOTLP(ctx, input, agentName, stop, counters)
+	default:
+		return runElasticAPM(ctx, input, agentName, stop, counters)
+	}
+}
+
+// runElasticAPM generates load using the native Elastic APM agent,
+// which talks the elastic-apm intake protocol.
+func runElasticAPM(ctx context.Context, input models.Input, agentName string, stop <-chan struct{}, counters *Counters) error {
+	serviceName := input.ServiceName
+	if agentName != "" {
+		serviceName = agentName
+	}
+
+	tracer, err := newTracer(serviceName, input)
+	if err != nil {
+		return err
+	}
+	defer tracer.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, input.RunTimeout)
+	defer cancel()
+
+	// Transactions and errors are generated on independent goroutines,
+	// each gated only by its own limiter, so a slow -ef-rate can't
+	// throttle -tf-rate down to its cadence (and vice versa) the way a
+	// single loop waiting on both limiters in turn would.
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return runLimitedLoop(gctx, stop, input.TransactionLimiter, func() bool {
+			return counters.TransactionsSent() >= int64(input.TransactionLimit)
+		}, func() error {
+			counters.beginRequest()
+			sendTransaction(tracer, input, counters)
+			counters.endRequest()
+			return nil
+		})
+	})
+	g.Go(func() error {
+		return runLimitedLoop(gctx, stop, input.ErrorLimiter, func() bool {
+			return counters.ErrorsSent() >= int64(input.ErrorLimit)
+		}, func() error {
+			counters.beginRequest()
+			sendError(tracer, input, counters)
+			counters.endRequest()
+			return nil
+		})
+	})
+	runErr := g.Wait()
+
+	start := time.Now()
+	tracer.Flush(abortAfter(input.FlushTimeout))
+	counters.recordFlush(time.Since(start))
+	return runErr
+}
+
+// runLimitedLoop waits on limiter and calls send in a loop until ctx is
+// cancelled, stop is closed, limiter.Wait fails, or send returns an
+// error. Once done
+// reports the configured limit has been reached it stops sending but
+// keeps blocking until ctx/stop ends, rather than returning early or
+// busy-spinning. It's shared by runElasticAPM and runOTLP so the two
+// transports gate transactions and errors the same way.
+func runLimitedLoop(ctx context.Context, stop <-chan struct{}, limiter *rate.Limiter, done func() bool, send func() error) error {
+	for {
+		if done() {
+			select {
+			case <-ctx.Done():
+			case <-stop:
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-stop:
+			return nil
+		default:
+		}
+
+		if err := limiter.Wait(ctx); err != nil {
+			// Wait fails when ctx is cancelled, when the wait would
+			// outlast ctx's deadline, or when the limiter has no burst
+			// left (a rate-0 one-shot batch is spent); stop sending
+			// instead of bursting at the end.
+			return nil
+		}
+		if err := send(); err != nil {
+			return err
+		}
+	}
+}
+
+func newTracer(serviceName string, input models.Input) (*apm.Tracer, error) {
+	httpTransport, err := transport.NewHTTPTransport()
+	if err != nil {
+		return nil, err
+	}
+	httpTransport.SetServerURL(mustParseURL(input.ApmServerUrl))
+	if input.ApmServerSecret != "" {
+		httpTransport.SetSecretToken(input.ApmServerSecret)
+	}
+	if input.APIKey != "" {
+		httpTransport.SetAPIKey(input.APIKey)
+	}
+	return apm.NewTracerOptions(apm.TracerOptions{
+		ServiceName: serviceName,
+		Transport:   httpTransport,
+	})
+}
+
+// abortAfter returns a channel that closes once d elapses, suitable
+// for use as tracer.Flush's abort signal.
+func abortAfter(d time.Duration) <-chan struct{} {
+	abort := make(chan struct{})
+	time.AfterFunc(d, func() { close(abort) })
+	return abort
+}
+
+func mustParseURL(rawURL string) *url.URL {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func sendTransaction(tracer *apm.Tracer, input models.Input, counters *Counters) {
+	tx := tracer.StartTransaction("GET /", "request")
+	spanCount := input.SpanMinLimit + rand.Intn(input.SpanMaxLimit-input.SpanMinLimit+1)
+	for i := 0; i < spanCount; i++ {
+		span := tx.StartSpan("query", "db.query", nil)
+		span.Duration = time.Duration(rand.Intn(50)) * time.Millisecond
+		span.End()
+	}
+	tx.End()
+	counters.addTransaction(estimatedTransactionSize(spanCount))
+}
+
+func sendError(tracer *apm.Tracer, input models.Input, counters *Counters) {
+	frameCount := input.ErrorFrameMinLimit + rand.Intn(input.ErrorFrameMaxLimit-input.ErrorFrameMinLimit+1)
+	tracer.NewError(randomError(frameCount)).Send()
+	counters.addError(estimatedErrorSize(frameCount))
+}
+
+// estimatedTransactionSize and estimatedErrorSize give the -metrics-addr
+// bytes-sent counter a reasonable value without needing the agent to
+// expose the size of what it actually put on the wire.
+func estimatedTransactionSize(spanCount int) int { return 200 + spanCount*120 }
+func estimatedErrorSize(frameCount int) int      { return 150 + frameCount*80 }
+
+func randomError(frameCount int) error {
+	return &syntheticError{frames: frameCount}
+}
+
+type syntheticError struct {
+	frames int
+}
+
+func (e *syntheticError) Error() string {
+	return "synthetic error generated by hey-apm"
+}
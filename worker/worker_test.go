@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/elastic/hey-apm/models"
+)
+
+// TestRunStopsOnceLimitsAreMet guards against the gating loop running on
+// once both TransactionLimit and ErrorLimit are already satisfied: with
+// stop already closed, Run should return promptly rather than only when
+// RunTimeout elapses, which here is set far longer than the test should
+// take.
+func TestRunStopsOnceLimitsAreMet(t *testing.T) {
+	input := models.Input{
+		ApmServerUrl:       "http://localhost:8200",
+		ServiceName:        "test-service",
+		RunTimeout:         time.Minute,
+		FlushTimeout:       10 * time.Millisecond,
+		TransactionLimit:   0,
+		ErrorLimit:         0,
+		SpanMaxLimit:       1,
+		SpanMinLimit:       1,
+		ErrorFrameMaxLimit: 1,
+		ErrorFrameMinLimit: 0,
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(context.Background(), input, "", stop, &Counters{})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return promptly once stop was closed with limits already met")
+	}
+}
+
+// TestRunLimitedLoopsAreIndependent guards against transactions and
+// errors being gated by a single loop that waits on both limiters in
+// turn, which throttles the faster event type down to the slower
+// one's rate. Driven with the same 100/10-burst vs 10/2-burst shape
+// -bench mode uses, the faster transaction loop should send well over
+// the error loop's count, not be capped down to it.
+func TestRunLimitedLoopsAreIndependent(t *testing.T) {
+	var txCount, errCount int64
+	txLimiter := rate.NewLimiter(100, 10)
+	errLimiter := rate.NewLimiter(10, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		runLimitedLoop(ctx, stop, txLimiter, func() bool { return false }, func() error {
+			atomic.AddInt64(&txCount, 1)
+			return nil
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		runLimitedLoop(ctx, stop, errLimiter, func() bool { return false }, func() error {
+			atomic.AddInt64(&errCount, 1)
+			return nil
+		})
+	}()
+	wg.Wait()
+
+	tx, errs := atomic.LoadInt64(&txCount), atomic.LoadInt64(&errCount)
+	if tx <= errs*2 {
+		t.Fatalf("transactions (%d) should significantly outpace errors (%d) given independent 100/s vs 10/s limiters; "+
+			"a single loop waiting on both limiters in turn would cap them close together", tx, errs)
+	}
+}